@@ -0,0 +1,103 @@
+package main
+
+import (
+	"log"
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+// asset holds the pre-generated QR code and map image paths for one
+// Location, produced by generateAssets ahead of PDF assembly.
+type asset struct {
+	qrPath  string
+	mapPath string
+}
+
+type assetJob struct {
+	index int
+	loc   *Location
+}
+
+type assetResult struct {
+	index int
+	asset asset
+}
+
+// generateAssets renders every Location's QR code and map image across a
+// bounded pool of -jobs workers. Jobs may finish out of order, but results
+// are collected back into a slice indexed by CSV position, so PDF assembly
+// still proceeds in CSV order afterwards.
+func generateAssets(locations []*Location) []asset {
+	jobCount := c.jobs
+	if jobCount <= 0 {
+		jobCount = runtime.NumCPU()
+	}
+
+	jobs := make(chan assetJob)
+	results := make(chan assetResult)
+	assets := make([]asset, len(locations))
+
+	var workers sync.WaitGroup
+	workers.Add(jobCount)
+	for w := 0; w < jobCount; w++ {
+		go func() {
+			defer workers.Done()
+			for j := range jobs {
+				results <- assetResult{
+					index: j.index,
+					asset: asset{
+						qrPath:  j.loc.getQRCode(),
+						mapPath: j.loc.getMap(),
+					},
+				}
+			}
+		}()
+	}
+
+	go func() {
+		workers.Wait()
+		close(results)
+	}()
+
+	go func() {
+		for i, loc := range locations {
+			jobs <- assetJob{index: i, loc: loc}
+		}
+		close(jobs)
+	}()
+
+	progress := newProgressReporter(len(locations))
+	for r := range results {
+		assets[r.index] = r.asset
+		progress.tick()
+	}
+
+	return assets
+}
+
+// progressReporter prints "n/total done, k tiles fetched, cache hit rate x%"
+// after every asset, when -progress is set.
+type progressReporter struct {
+	enabled bool
+	total   int
+	done    int64
+}
+
+func newProgressReporter(total int) *progressReporter {
+	return &progressReporter{enabled: c.progress, total: total}
+}
+
+func (p *progressReporter) tick() {
+	if !p.enabled {
+		return
+	}
+	done := atomic.AddInt64(&p.done, 1)
+	fetched := atomic.LoadInt64(&tilesFetched)
+	hits := atomic.LoadInt64(&tilesCacheHits)
+	hitRate := 0.0
+	if total := fetched + hits; total > 0 {
+		hitRate = float64(hits) / float64(total) * 100
+	}
+	log.Printf("%d/%d done, %d tiles fetched, cache hit rate %.0f%%", done, p.total, fetched, hitRate)
+}
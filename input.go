@@ -0,0 +1,214 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/gocarina/gocsv"
+	"github.com/golang/geo/s2"
+)
+
+// loadLocations reads the given input file into an ordered slice of
+// Locations, plus an optional track polyline, dispatching on file
+// extension. CSV files (the original format) carry no separate track: the
+// locations themselves define the route order. GPX and KML files carry
+// their own track/LineString geometry, which is returned separately from
+// the waypoint/Placemark Locations.
+func loadLocations(path string) ([]*Location, []s2.LatLng, error) {
+	var locations []*Location
+	var track []s2.LatLng
+	var err error
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".gpx":
+		locations, track, err = loadGPX(path)
+	case ".kml":
+		locations, track, err = loadKML(path)
+	default:
+		locations, err = loadCSV(path)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err := checkDuplicateNumbers(locations); err != nil {
+		return nil, nil, err
+	}
+	return locations, track, nil
+}
+
+// checkDuplicateNumbers rejects input with repeated Location.Number values.
+// Asset generation and caching are keyed by Number (see Location.getQRCode
+// and Location.getMap), and generateAssets renders locations concurrently,
+// so two locations sharing a Number would race to write the same cache
+// files.
+func checkDuplicateNumbers(locations []*Location) error {
+	seen := make(map[string]bool, len(locations))
+	for _, loc := range locations {
+		if seen[loc.Number] {
+			return fmt.Errorf("duplicate location number %q", loc.Number)
+		}
+		seen[loc.Number] = true
+	}
+	return nil
+}
+
+func loadCSV(path string) ([]*Location, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("opening file: %w", err)
+	}
+	defer f.Close()
+
+	locations := []*Location{}
+	if err := gocsv.UnmarshalFile(f, &locations); err != nil {
+		return nil, fmt.Errorf("parsing CSV: %w", err)
+	}
+	return locations, nil
+}
+
+type gpxFile struct {
+	XMLName   xml.Name   `xml:"gpx"`
+	Waypoints []gpxPoint `xml:"wpt"`
+	Tracks    []gpxTrack `xml:"trk"`
+}
+
+type gpxTrack struct {
+	Segments []gpxSegment `xml:"trkseg"`
+}
+
+type gpxSegment struct {
+	Points []gpxPoint `xml:"trkpt"`
+}
+
+type gpxPoint struct {
+	Lat  float64 `xml:"lat,attr"`
+	Lon  float64 `xml:"lon,attr"`
+	Name string  `xml:"name"`
+}
+
+func loadGPX(path string) ([]*Location, []s2.LatLng, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("opening file: %w", err)
+	}
+	defer f.Close()
+
+	var doc gpxFile
+	if err := xml.NewDecoder(f).Decode(&doc); err != nil {
+		return nil, nil, fmt.Errorf("parsing GPX: %w", err)
+	}
+
+	locations := make([]*Location, len(doc.Waypoints))
+	for i, wpt := range doc.Waypoints {
+		locations[i] = &Location{
+			Number:    strconv.Itoa(i + 1),
+			Name:      wpt.Name,
+			Latitude:  wpt.Lat,
+			Longitude: wpt.Lon,
+		}
+	}
+
+	var track []s2.LatLng
+	for _, trk := range doc.Tracks {
+		for _, seg := range trk.Segments {
+			for _, pt := range seg.Points {
+				track = append(track, s2.LatLngFromDegrees(pt.Lat, pt.Lon))
+			}
+		}
+	}
+
+	return locations, track, nil
+}
+
+type kmlFile struct {
+	XMLName  xml.Name `xml:"kml"`
+	Document struct {
+		Placemarks []kmlPlacemark `xml:"Placemark"`
+	} `xml:"Document"`
+}
+
+type kmlPlacemark struct {
+	Name       string         `xml:"name"`
+	Point      *kmlCoordsNode `xml:"Point"`
+	LineString *kmlCoordsNode `xml:"LineString"`
+}
+
+type kmlCoordsNode struct {
+	Coordinates string `xml:"coordinates"`
+}
+
+func loadKML(path string) ([]*Location, []s2.LatLng, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("opening file: %w", err)
+	}
+	defer f.Close()
+
+	var doc kmlFile
+	if err := xml.NewDecoder(f).Decode(&doc); err != nil {
+		return nil, nil, fmt.Errorf("parsing KML: %w", err)
+	}
+
+	var locations []*Location
+	var track []s2.LatLng
+	for _, pm := range doc.Document.Placemarks {
+		switch {
+		case pm.Point != nil:
+			lat, lon, err := parseKMLCoordinate(pm.Point.Coordinates)
+			if err != nil {
+				return nil, nil, fmt.Errorf("placemark %q: %w", pm.Name, err)
+			}
+			locations = append(locations, &Location{
+				Number:    strconv.Itoa(len(locations) + 1),
+				Name:      pm.Name,
+				Latitude:  lat,
+				Longitude: lon,
+			})
+		case pm.LineString != nil:
+			points, err := parseKMLCoordinates(pm.LineString.Coordinates)
+			if err != nil {
+				return nil, nil, fmt.Errorf("placemark %q: %w", pm.Name, err)
+			}
+			track = append(track, points...)
+		}
+	}
+
+	return locations, track, nil
+}
+
+// parseKMLCoordinate parses a single KML "lon,lat[,alt]" tuple.
+func parseKMLCoordinate(s string) (lat, lon float64, err error) {
+	parts := strings.Split(strings.TrimSpace(s), ",")
+	if len(parts) < 2 {
+		return 0, 0, fmt.Errorf("malformed coordinates %q", s)
+	}
+	lon, err = strconv.ParseFloat(parts[0], 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("malformed longitude in %q: %w", s, err)
+	}
+	lat, err = strconv.ParseFloat(parts[1], 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("malformed latitude in %q: %w", s, err)
+	}
+	return lat, lon, nil
+}
+
+// parseKMLCoordinates parses a KML "coordinates" text of whitespace
+// separated "lon,lat[,alt]" tuples, as found in a LineString.
+func parseKMLCoordinates(s string) ([]s2.LatLng, error) {
+	fields := strings.Fields(s)
+	points := make([]s2.LatLng, 0, len(fields))
+	for _, field := range fields {
+		lat, lon, err := parseKMLCoordinate(field)
+		if err != nil {
+			return nil, err
+		}
+		points = append(points, s2.LatLngFromDegrees(lat, lon))
+	}
+	return points, nil
+}
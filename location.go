@@ -73,9 +73,20 @@ func (l *Location) getMap() string {
 		ctx.AddMarker(sm.NewMarker(kh, color.RGBA{0, 0, 0xff, 0xff}, 32.0))
 		// ctx.SetCenter(s2.LatLngFromDegrees(19.89830, 99.81805))
 		// ctx.SetCenter(pos)
-		// ctx.SetTileProvider(sm.NewTileProviderOpenTopoMap())
-		// ctx.SetTileProvider(sm.NewTileProviderThunderforestOutdoors())
-		ctx.SetTileProvider(sm.NewTileProviderThunderforestLandscape())
+
+		provider, err := getTileProvider()
+		if err != nil {
+			log.Fatal("Failed to configure tile provider: ", err)
+		}
+		ctx.SetTileProvider(provider)
+		ctx.SetCache(newDiskTileFetcher(provider))
+
+		if activeRoute != nil {
+			ctx.AddPath(activeRoute.path())
+			for _, seg := range activeRoute.highlightSegments(l) {
+				ctx.AddPath(seg)
+			}
+		}
 
 		if c.debug {
 			log.Printf("Rendering map for %s", l.Number)
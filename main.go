@@ -3,10 +3,10 @@ package main
 import (
 	"flag"
 	"fmt"
-	"github.com/gocarina/gocsv"
 	"github.com/jung-kurt/gofpdf"
 	"log"
 	"os"
+	"runtime"
 	"time"
 )
 
@@ -22,8 +22,25 @@ var c struct {
 	cDate time.Time
 	debug bool
 	force bool
+
+	tiles        string
+	tilesAPIKey  string
+	tilesURL     string
+	tilesOffline bool
+
+	route          bool
+	overviewMargin float64
+
+	jobs          int
+	tilesRPS      float64
+	tilesRPSBurst int
+	progress      bool
 }
 
+// activeRoute is set in main when -route is given, and consulted by
+// Location.getMap to overlay the route and highlight each location's segment.
+var activeRoute *Route
+
 func main() {
 	flag.StringVar(&c.in, "in", "", "Input CSV file")
 	flag.StringVar(&c.out, "out", "", "Output PDF file")
@@ -31,6 +48,16 @@ func main() {
 	flag.BoolVar(&c.debug, "debug", false, "Debug output")
 	flag.BoolVar(&c.force, "force", false, "No cache usage")
 	// flag.StringVar(&c.outPrefix, "outPrefix", "", "Output file prefix")
+	flag.StringVar(&c.tiles, "tiles", "thunderforest-landscape", "Tile provider (osm, opentopo, thunderforest-landscape, thunderforest-outdoors, stamen-terrain, stamen-toner, carto-light, carto-dark, custom-url)")
+	flag.StringVar(&c.tilesAPIKey, "tiles-apikey", "", "API key for keyed tile providers (thunderforest)")
+	flag.StringVar(&c.tilesURL, "tiles-url", "", "Custom XYZ tile URL template, used when -tiles=custom-url")
+	flag.BoolVar(&c.tilesOffline, "tiles-offline", false, "Fail instead of fetching tiles over HTTP; only use already-cached tiles")
+	flag.BoolVar(&c.route, "route", false, "Draw a connected route across the ordered locations")
+	flag.Float64Var(&c.overviewMargin, "overview-margin", 0.05, "Margin, in degrees, added around the overview page's auto-fit bounding box")
+	flag.IntVar(&c.jobs, "jobs", runtime.NumCPU(), "Number of locations to render concurrently")
+	flag.Float64Var(&c.tilesRPS, "tiles-rps", 2, "Maximum tile requests per second")
+	flag.IntVar(&c.tilesRPSBurst, "tiles-rps-burst", 4, "Burst size for -tiles-rps")
+	flag.BoolVar(&c.progress, "progress", false, "Report rendering progress as assets complete")
 
 	flag.Parse()
 
@@ -44,23 +71,20 @@ func main() {
 	if c.debug {
 		log.Printf("Reading file %s", c.in)
 	}
-	f, err := os.Open(c.in)
-	if err != nil {
-		log.Fatal("Error opening file: %s", err)
-	}
-	defer f.Close()
-	locations := []*Location{}
-
-	err = gocsv.UnmarshalFile(f, &locations)
+	locations, track, err := loadLocations(c.in)
 	if err != nil {
-		log.Fatal("Error parsing CSV: ", err)
+		log.Fatal("Error reading input: ", err)
 	}
 	fi, err := os.Stat(c.in)
 	if err != nil {
-		log.Fatal("Error statting CSV: ", err)
+		log.Fatal("Error statting input file: ", err)
 	}
 	c.cDate = fi.ModTime()
 
+	if c.route {
+		activeRoute = NewRoute(locations, track)
+	}
+
 	log.Printf("Processing %d locations", len(locations))
 
 	err = os.MkdirAll(c.cache, os.ModePerm)
@@ -79,6 +103,10 @@ func main() {
 	pdf.SetTextColor(0, 0, 0)
 	tr := pdf.UnicodeTranslatorFromDescriptor("cp874")
 
+	renderOverviewPage(pdf, locations, activeRoute)
+
+	assets := generateAssets(locations)
+
 	// Generate the PDF
 	for i := range locations {
 		loc := locations[i]
@@ -90,11 +118,11 @@ func main() {
 		imageOpts := gofpdf.ImageOptions{"PNG", true}
 
 		// QR Code
-		qrPath := loc.getQRCode()
+		qrPath := assets[i].qrPath
 		// qrInfo := pdf.RegisterImageOptions(qrPath, imageOpts)
 
 		// Map
-		smPath := loc.getMap()
+		smPath := assets[i].mapPath
 		// mapInfo := pdf.RegisterImageOptions(smPath, imageOpts)
 
 		pdf.TransformBegin()
@@ -116,6 +144,13 @@ func main() {
 		coords := fmt.Sprintf("%f N, %f E", loc.Latitude, loc.Longitude)
 		pdf.Text(5, pHeight+5+7, coords)
 
+		if activeRoute != nil {
+			if label := activeRoute.segmentLabel(loc); label != "" {
+				pdf.SetFontSize(15)
+				pdf.Text(5, pHeight+5+14, fmt.Sprintf("Next: %s", label))
+			}
+		}
+
 		pdf.ImageOptions(qrPath, pHeight-27, pHeight-11, 0, 0, false, imageOpts, 0, "")
 		pdf.TransformEnd()
 
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"image/color"
+	"log"
+	"path/filepath"
+
+	sm "github.com/flopp/go-staticmaps"
+	"github.com/fogleman/gg"
+	"github.com/golang/geo/s2"
+	"github.com/jung-kurt/gofpdf"
+)
+
+// boundingRect folds every location's LatLng into the tightest s2.Rect that
+// contains them all, then expands it by -overview-margin degrees so markers
+// near the edge of the route aren't drawn flush against the page border.
+func boundingRect(locations []*Location) s2.Rect {
+	rect := s2.EmptyRect()
+	for _, loc := range locations {
+		rect = rect.AddPoint(s2.LatLngFromDegrees(loc.Latitude, loc.Longitude))
+	}
+	margin := s2.LatLngFromDegrees(c.overviewMargin, c.overviewMargin)
+	return rect.Expanded(margin)
+}
+
+// numberedMarker renders a small filled circle with the location's number
+// burned in via gg, for use as an overview page marker image.
+func numberedMarker(number string) *gg.Context {
+	const size = 44.0
+	dc := gg.NewContext(int(size), int(size))
+	dc.SetColor(color.RGBA{0xcc, 0, 0, 0xff})
+	dc.DrawCircle(size/2, size/2, size/2-2)
+	dc.Fill()
+	dc.SetColor(color.White)
+	dc.DrawStringAnchored(number, size/2, size/2, 0.5, 0.5)
+	return dc
+}
+
+// renderOverviewPage draws every location on one auto-fit map ahead of the
+// per-location pages, numbered to match the CSV Number column, with a
+// legend down the right side of the page mapping number to name.
+func renderOverviewPage(pdf *gofpdf.Fpdf, locations []*Location, route *Route) {
+	path := filepath.Join(c.cache, "overview-map.png")
+
+	ctx := sm.NewContext()
+	ctx.SetSize(960, 770)
+
+	provider, err := getTileProvider()
+	if err != nil {
+		log.Fatal("Failed to configure tile provider: ", err)
+	}
+	ctx.SetTileProvider(provider)
+	ctx.SetCache(newDiskTileFetcher(provider))
+
+	rect := boundingRect(locations)
+	ctx.SetBoundingBox(rect.Lo(), rect.Hi())
+
+	if route != nil {
+		ctx.AddPath(route.path())
+	}
+	for _, loc := range locations {
+		pos := s2.LatLngFromDegrees(loc.Latitude, loc.Longitude)
+		marker := numberedMarker(loc.Number)
+		ctx.AddObject(sm.NewImageMarker(pos, marker.Image(), float64(marker.Width())/2, float64(marker.Height())/2))
+	}
+
+	img, err := ctx.Render()
+	if err != nil {
+		log.Fatal("Failed to render overview map: ", err)
+	}
+	if err := gg.SavePNG(path, img); err != nil {
+		log.Fatal("Failed to create overview image: ", err)
+	}
+
+	pdf.AddPage()
+	pageW, pageH := pdf.GetPageSize()
+	mapW := pageW * 0.65
+	pdf.ImageOptions(path, 5, 5, mapW, pageH-10, false, gofpdf.ImageOptions{"PNG", true}, 0, "")
+
+	pdf.SetFont("Norasi", "", 12)
+	pdf.SetTextColor(0, 0, 0)
+	tr := pdf.UnicodeTranslatorFromDescriptor("cp874")
+	legendX := mapW + 10
+	y := 10.0
+	for _, loc := range locations {
+		if y > pageH-5 {
+			// The legend column next to the map only fits so many rows;
+			// continue on dedicated legend-only pages instead of silently
+			// dropping the rest (every location still gets its own page
+			// later, but a trip long enough to need continuation pages is
+			// exactly when a complete overview legend matters most).
+			pdf.AddPage()
+			legendX = 5
+			y = 10
+		}
+		pdf.Text(legendX, y, fmt.Sprintf("%s  %s", loc.Number, tr(loc.Name)))
+		y += 5
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"image/color"
+
+	sm "github.com/flopp/go-staticmaps"
+	"github.com/golang/geo/s2"
+)
+
+// earthRadiusMeters is used to turn s2.LatLng.Distance's angular result
+// into a ground distance.
+const earthRadiusMeters = 6371008.8
+
+var (
+	routeColor          = color.RGBA{0, 0x80, 0xff, 0xc0}
+	routeHighlightColor = color.RGBA{0xff, 0x40, 0, 0xff}
+)
+
+// Route is the ordered track drawn across the per-location maps and the
+// overview page when -route is set. Locations is always the CSV/GPX/KML
+// order used for numbering and segment distances; Points is the geometry
+// actually drawn, which is the same latlngs for a CSV (or a GPX/KML file
+// with no track) but the finer-grained <trk>/<LineString> track otherwise.
+type Route struct {
+	Locations []*Location
+	Points    []s2.LatLng
+}
+
+// NewRoute builds a Route from the ordered locations and, if the input
+// carried its own track geometry (GPX <trk> or KML <LineString>), that
+// track. When track is empty the locations themselves are used as the
+// route geometry.
+func NewRoute(locations []*Location, track []s2.LatLng) *Route {
+	points := track
+	if len(points) == 0 {
+		points = make([]s2.LatLng, len(locations))
+		for i, loc := range locations {
+			points[i] = s2.LatLngFromDegrees(loc.Latitude, loc.Longitude)
+		}
+	}
+	return &Route{Locations: locations, Points: points}
+}
+
+// path returns the full route geometry as an sm.Path for overlay on a map.
+func (r *Route) path() *sm.Path {
+	return sm.NewPath(r.Points, routeColor, 4.0)
+}
+
+// indexOf returns loc's position within the route's Locations, if present.
+func (r *Route) indexOf(loc *Location) (int, bool) {
+	for i, l := range r.Locations {
+		if l == loc {
+			return i, true
+		}
+	}
+	return -1, false
+}
+
+// highlightSegments returns the one or two segments ([i-1,i] and [i,i+1])
+// adjacent to loc, drawn in a contrasting color so the per-location page
+// shows where in the route that location sits.
+func (r *Route) highlightSegments(loc *Location) []*sm.Path {
+	i, ok := r.indexOf(loc)
+	if !ok {
+		return nil
+	}
+
+	var paths []*sm.Path
+	if i > 0 {
+		paths = append(paths, sm.NewPath([]s2.LatLng{
+			s2.LatLngFromDegrees(r.Locations[i-1].Latitude, r.Locations[i-1].Longitude),
+			s2.LatLngFromDegrees(r.Locations[i].Latitude, r.Locations[i].Longitude),
+		}, routeHighlightColor, 5.0))
+	}
+	if i+1 < len(r.Locations) {
+		paths = append(paths, sm.NewPath([]s2.LatLng{
+			s2.LatLngFromDegrees(r.Locations[i].Latitude, r.Locations[i].Longitude),
+			s2.LatLngFromDegrees(r.Locations[i+1].Latitude, r.Locations[i+1].Longitude),
+		}, routeHighlightColor, 5.0))
+	}
+	return paths
+}
+
+// segmentDistanceMeters returns the great-circle distance between
+// locations i and i+1.
+func (r *Route) segmentDistanceMeters(i int) float64 {
+	a := s2.LatLngFromDegrees(r.Locations[i].Latitude, r.Locations[i].Longitude)
+	b := s2.LatLngFromDegrees(r.Locations[i+1].Latitude, r.Locations[i+1].Longitude)
+	return float64(a.Distance(b)) * earthRadiusMeters
+}
+
+// segmentLabel renders the distance from loc to the next location in the
+// route, e.g. "350 m" or "1.2 km". It returns "" for the last location.
+func (r *Route) segmentLabel(loc *Location) string {
+	i, ok := r.indexOf(loc)
+	if !ok || i+1 >= len(r.Locations) {
+		return ""
+	}
+	d := r.segmentDistanceMeters(i)
+	if d >= 1000 {
+		return fmt.Sprintf("%.1f km", d/1000)
+	}
+	return fmt.Sprintf("%.0f m", d)
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"image"
+	"image/png"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+
+	sm "github.com/flopp/go-staticmaps"
+	"golang.org/x/time/rate"
+)
+
+// tileProviders maps the -tiles flag to a constructor for the matching
+// sm.TileProvider. thunderforest-landscape was the only option before this
+// flag existed, so it stays the default.
+var tileProviders = map[string]func() *sm.TileProvider{
+	"osm":                     sm.NewTileProviderOpenStreetMaps,
+	"opentopo":                sm.NewTileProviderOpenTopoMap,
+	"thunderforest-landscape": sm.NewTileProviderThunderforestLandscape,
+	"thunderforest-outdoors":  sm.NewTileProviderThunderforestOutdoors,
+	"stamen-terrain":          sm.NewTileProviderStamenTerrain,
+	"stamen-toner":            sm.NewTileProviderStamenToner,
+	"carto-light":             sm.NewTileProviderCartoLight,
+	"carto-dark":              sm.NewTileProviderCartoDark,
+}
+
+// keyedProviders need -tiles-apikey appended to their URL pattern to work.
+var keyedProviders = map[string]bool{
+	"thunderforest-landscape": true,
+	"thunderforest-outdoors":  true,
+}
+
+// buildTileProvider turns -tiles (plus -tiles-apikey / -tiles-url) into a
+// concrete sm.TileProvider.
+func buildTileProvider() (*sm.TileProvider, error) {
+	name := c.tiles
+	if name == "" {
+		name = "thunderforest-landscape"
+	}
+
+	if name == "custom-url" {
+		if c.tilesURL == "" {
+			return nil, fmt.Errorf("-tiles=custom-url requires -tiles-url")
+		}
+		p := sm.NewTileProviderOpenStreetMaps()
+		p.Name = "custom-url"
+		p.URLPattern = c.tilesURL
+		p.Shards = nil
+		return p, nil
+	}
+
+	newProvider, ok := tileProviders[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown tile provider %q", name)
+	}
+	p := newProvider()
+	if keyedProviders[name] {
+		if c.tilesAPIKey == "" {
+			return nil, fmt.Errorf("-tiles=%s requires -tiles-apikey", name)
+		}
+		p.URLPattern = p.URLPattern + "?apikey=" + url.QueryEscape(c.tilesAPIKey)
+	}
+	return p, nil
+}
+
+var (
+	tileProviderOnce sync.Once
+	tileProvider     *sm.TileProvider
+	tileProviderErr  error
+)
+
+// getTileProvider lazily builds and caches the configured tile provider so
+// every Location shares the same one instead of re-parsing flags per page.
+func getTileProvider() (*sm.TileProvider, error) {
+	tileProviderOnce.Do(func() {
+		tileProvider, tileProviderErr = buildTileProvider()
+	})
+	return tileProvider, tileProviderErr
+}
+
+// tileProviderCacheName picks the on-disk cache directory for provider. It's
+// keyed off a hash of the resolved URL template, not just the -tiles name,
+// so pointing -tiles=custom-url (or a keyed provider) at a different
+// -tiles-url / -tiles-apikey doesn't silently serve the previous source's
+// cached tiles.
+func tileProviderCacheName(provider *sm.TileProvider) string {
+	name := c.tiles
+	if name == "" {
+		name = "thunderforest-landscape"
+	}
+	sum := sha256.Sum256([]byte(provider.URLPattern))
+	return fmt.Sprintf("%s-%x", name, sum[:6])
+}
+
+var (
+	tileLimiterOnce sync.Once
+	tileLimiter     *rate.Limiter
+)
+
+// getTileLimiter lazily builds the shared -tiles-rps token bucket, so all
+// worker goroutines draw from the same budget instead of each getting their
+// own N requests/sec.
+func getTileLimiter() *rate.Limiter {
+	tileLimiterOnce.Do(func() {
+		tileLimiter = rate.NewLimiter(rate.Limit(c.tilesRPS), c.tilesRPSBurst)
+	})
+	return tileLimiter
+}
+
+// tilesFetched and tilesCacheHits are read by the -progress reporter to show
+// a running cache hit rate across all worker goroutines.
+var (
+	tilesFetched   int64
+	tilesCacheHits int64
+)
+
+// tileWriteLocks serializes cache reads/fetches/writes for a given on-disk
+// tile path. Every Location.getMap call builds its own diskTileFetcher, and
+// -jobs runs many of those concurrently, so two locations whose viewports
+// overlap (every map always includes the fixed kh marker alongside the
+// location, so this is routine, not an edge case) can race to fetch and
+// savePNG the same path at once. Keying the lock by path, rather than using
+// one lock for the whole cache, keeps unrelated tiles fetching in parallel.
+var tileWriteLocks sync.Map // map[string]*sync.Mutex
+
+func lockTilePath(path string) *sync.Mutex {
+	mu, _ := tileWriteLocks.LoadOrStore(path, &sync.Mutex{})
+	return mu.(*sync.Mutex)
+}
+
+// diskTileFetcher wraps an sm.TileFetcher with an on-disk cache so repeat
+// runs over the same CSV don't re-download tiles. Tiles are stored under
+// cache/tiles/{provider}-{urlhash}/{z}/{x}/{y}.png. With -tiles-offline set,
+// a cache miss is a fatal error instead of falling through to HTTP; with
+// -force set, cached tiles are never read: every tile is fetched again and
+// the fresh copy is written back to the cache, as -force does for QR codes.
+type diskTileFetcher struct {
+	inner   *sm.TileFetcher
+	dir     string
+	offline bool
+	force   bool
+}
+
+func newDiskTileFetcher(provider *sm.TileProvider) *diskTileFetcher {
+	return &diskTileFetcher{
+		inner:   sm.NewTileFetcher(provider),
+		dir:     filepath.Join(c.cache, "tiles", tileProviderCacheName(provider)),
+		offline: c.tilesOffline,
+		force:   c.force,
+	}
+}
+
+func (f *diskTileFetcher) tilePath(zoom, x, y int) string {
+	return filepath.Join(f.dir, fmt.Sprintf("%d", zoom), fmt.Sprintf("%d", x), fmt.Sprintf("%d.png", y))
+}
+
+func (f *diskTileFetcher) Fetch(zoom, x, y int) (image.Image, error) {
+	path := f.tilePath(zoom, x, y)
+
+	mu := lockTilePath(path)
+	mu.Lock()
+	defer mu.Unlock()
+
+	if !f.force {
+		if img, err := loadPNG(path); err == nil {
+			if c.debug {
+				log.Printf("Using cached tile %s", path)
+			}
+			atomic.AddInt64(&tilesCacheHits, 1)
+			return img, nil
+		}
+	}
+
+	if f.offline {
+		return nil, fmt.Errorf("tile %d/%d/%d not cached and -tiles-offline is set", zoom, x, y)
+	}
+
+	if err := getTileLimiter().Wait(context.Background()); err != nil {
+		return nil, err
+	}
+	img, err := f.inner.Fetch(zoom, x, y)
+	if err != nil {
+		return nil, err
+	}
+	atomic.AddInt64(&tilesFetched, 1)
+	if err := savePNG(path, img); err != nil {
+		log.Printf("Failed to cache tile %s: %s", path, err)
+	}
+	return img, nil
+}
+
+func loadPNG(path string) (image.Image, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return png.Decode(f)
+}
+
+// savePNG writes img to a temp file in path's directory and renames it into
+// place, so a reader never observes a partially-written file at path.
+func savePNG(path string, img image.Image) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(dir, ".tmp-*.png")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := png.Encode(tmp, img); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
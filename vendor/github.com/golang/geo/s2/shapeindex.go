@@ -17,7 +17,10 @@ limitations under the License.
 package s2
 
 import (
+	"container/heap"
+
 	"github.com/golang/geo/r2"
+	"github.com/golang/geo/s1"
 )
 
 // dimension defines the types of geometry dimensions that a Shape supports.
@@ -203,6 +206,13 @@ type ShapeIndex struct {
 	// nextID tracks the next ID to hand out. IDs are not reused when shapes
 	// are removed from the index.
 	nextID int
+
+	// cellMap holds the built index: each leaf CellID that holds edges maps
+	// to the clipped shapes whose edges fall in that cell, and shapes that
+	// have an interior but no edges are added under each face's root cell.
+	// Cells that no edge reaches are not stored. It is populated lazily by
+	// maybeApplyUpdates and invalidated (set to nil) by Add/Remove/Reset.
+	cellMap map[CellID]*shapeIndexCell
 }
 
 // NewShapeIndex creates a new ShapeIndex.
@@ -217,6 +227,7 @@ func NewShapeIndex() *ShapeIndex {
 func (s *ShapeIndex) Add(shape Shape) {
 	s.shapes[s.nextID] = shape
 	s.nextID++
+	s.cellMap = nil
 }
 
 // Remove removes the given shape from the index.
@@ -224,6 +235,7 @@ func (s *ShapeIndex) Remove(shape Shape) {
 	for k, v := range s.shapes {
 		if v == shape {
 			delete(s.shapes, k)
+			s.cellMap = nil
 			return
 		}
 	}
@@ -238,6 +250,7 @@ func (s *ShapeIndex) Len() int {
 func (s *ShapeIndex) Reset() {
 	s.shapes = make(map[int]Shape)
 	s.nextID = 0
+	s.cellMap = nil
 }
 
 // NumEdges returns the number of edges in this index.
@@ -248,3 +261,313 @@ func (s *ShapeIndex) NumEdges() int {
 	}
 	return numEdges
 }
+
+// edgeMaxLevel returns the deepest cell level worth subdividing an edge
+// down to: once a cell's average edge length is no longer longer than the
+// index edge, splitting further just duplicates the same edge into
+// ever-smaller sibling cells for no benefit.
+func edgeMaxLevel(a, b Point) int {
+	length := ChordAngleBetweenPoints(a, b).Angle().Radians()
+	return AvgEdgeMetric.MaxLevel(length)
+}
+
+// maybeApplyUpdates builds cellMap from the index's shapes if it isn't
+// already built. Each shape's edges are clipped to every cube face they
+// actually touch (ClipToPaddedFace), recorded as a faceEdge per face, then
+// recursively subdivided by updateFaceEdges until every leaf cell holds at
+// most maxEdgesPerCell edges or none of its edges may go below its
+// edgeMaxLevel.
+func (s *ShapeIndex) maybeApplyUpdates() {
+	if s.cellMap != nil {
+		return
+	}
+	s.cellMap = make(map[CellID]*shapeIndexCell)
+
+	var perFace [6][]*clippedEdge
+	for id, shape := range s.shapes {
+		n := shape.NumEdges()
+		for e := 0; e < n; e++ {
+			a, b := shape.Edge(e)
+			maxLvl := edgeMaxLevel(a, b)
+			for f := 0; f < 6; f++ {
+				aUV, bUV, ok := ClipToPaddedFace(a, b, f, cellPadding)
+				if !ok {
+					continue
+				}
+				fe := &faceEdge{
+					shapeID:     int32(id),
+					edgeID:      e,
+					maxLevel:    maxLvl,
+					hasInterior: shape.HasInterior(),
+					a:           aUV,
+					b:           bUV,
+					va:          a,
+					vb:          b,
+				}
+				perFace[f] = append(perFace[f], &clippedEdge{
+					faceEdge: fe,
+					bound:    r2.RectFromPoints(aUV, bUV),
+				})
+			}
+		}
+		if n == 0 && shape.HasInterior() {
+			// A shape with no edges (e.g. a full polygon) still needs a
+			// clippedShape recorded so Contains can report it. cellContaining
+			// only ever walks up the ancestors of the query point's own leaf
+			// cell, which reaches a given face's root only for query points
+			// on that face -- so the shape must be registered under every
+			// face's root cell, not just face 0, or points on faces 1-5
+			// would never find it.
+			for f := 0; f < 6; f++ {
+				root := CellIDFromFace(f)
+				cell := s.cellMap[root]
+				if cell == nil {
+					cell = &shapeIndexCell{}
+					s.cellMap[root] = cell
+				}
+				cell.add(&clippedShape{shapeID: int32(id), containsCenter: shape.ContainsOrigin()})
+			}
+		}
+	}
+
+	for f := 0; f < 6; f++ {
+		if len(perFace[f]) > 0 {
+			s.updateFaceEdges(CellIDFromFace(f), perFace[f])
+		}
+	}
+}
+
+// updateFaceEdges recursively subdivides id's cell, clipping each edge's
+// face-projected coordinates (faceEdge.a/b) to every child cell's padded
+// UV rect via ClipEdge -- not just bucketing it into whichever child
+// contains some single representative point -- so an edge that straddles
+// more than one child is correctly recorded in every child it overlaps.
+// clippedEdge.bound is used as a cheap reject before the exact ClipEdge
+// call. Recursion stops once a cell is at or under maxEdgesPerCell, or
+// once every remaining edge has reached its own edgeMaxLevel.
+func (s *ShapeIndex) updateFaceEdges(id CellID, edges []*clippedEdge) {
+	canSubdivide := id.Level() < maxLevel
+	if canSubdivide {
+		canSubdivide = false
+		for _, e := range edges {
+			if id.Level() < e.faceEdge.maxLevel {
+				canSubdivide = true
+				break
+			}
+		}
+	}
+
+	if len(edges) <= s.maxEdgesPerCell || !canSubdivide {
+		s.addCell(id, edges)
+		return
+	}
+
+	children := id.Children()
+	var buckets [4][]*clippedEdge
+	for i, child := range children {
+		padded := child.boundUV().ExpandedByMargin(cellPadding)
+		for _, e := range edges {
+			if !padded.Intersects(e.bound) {
+				continue
+			}
+			aClip, bClip, intersects := ClipEdge(e.faceEdge.a, e.faceEdge.b, padded)
+			if !intersects {
+				continue
+			}
+			buckets[i] = append(buckets[i], &clippedEdge{
+				faceEdge: e.faceEdge,
+				bound:    r2.RectFromPoints(aClip, bClip),
+			})
+		}
+	}
+
+	for i, child := range children {
+		if len(buckets[i]) > 0 {
+			s.updateFaceEdges(child, buckets[i])
+		}
+	}
+}
+
+// addCell records a leaf shapeIndexCell for id, with one clippedShape per
+// shape that has edges in this cell, and containsCenter computed by
+// bruteForceContains for shapes whose edges say they have an interior.
+func (s *ShapeIndex) addCell(id CellID, edges []*clippedEdge) {
+	type bucket struct {
+		hasInterior bool
+		edgeIDs     []int
+	}
+	byShape := make(map[int32]*bucket)
+	for _, e := range edges {
+		b := byShape[e.faceEdge.shapeID]
+		if b == nil {
+			b = &bucket{hasInterior: e.faceEdge.hasInterior}
+			byShape[e.faceEdge.shapeID] = b
+		}
+		b.edgeIDs = append(b.edgeIDs, e.faceEdge.edgeID)
+	}
+
+	center := CellFromCellID(id).Center()
+	cell := &shapeIndexCell{}
+	for shapeID, b := range byShape {
+		clipped := newClippedShape(shapeID, len(b.edgeIDs))
+		copy(clipped.edges, b.edgeIDs)
+		if b.hasInterior {
+			if shape := s.shapes[int(shapeID)]; shape != nil {
+				clipped.containsCenter = s.bruteForceContains(shape, center)
+			}
+		}
+		cell.add(clipped)
+	}
+	s.cellMap[id] = cell
+}
+
+// bruteForceContains reports whether p is contained by shape, by counting
+// how many of shape's edges cross the segment from s2.OriginPoint() to p:
+// an odd number of crossings flips shape.ContainsOrigin's state.
+func (s *ShapeIndex) bruteForceContains(shape Shape, p Point) bool {
+	inside := shape.ContainsOrigin()
+	origin := OriginPoint()
+	for e := 0; e < shape.NumEdges(); e++ {
+		a, b := shape.Edge(e)
+		if edgesCross(origin, p, a, b) {
+			inside = !inside
+		}
+	}
+	return inside
+}
+
+// edgesCross reports whether segment ab crosses segment cd, resolving the
+// degenerate vertex-touching case the same way Loop containment testing
+// does elsewhere in this package.
+func edgesCross(a, b, c, d Point) bool {
+	switch CrossingSign(a, b, c, d) {
+	case Cross:
+		return true
+	case MaybeCross:
+		return VertexCrossing(a, b, c, d)
+	default:
+		return false
+	}
+}
+
+// cellContaining returns the CellID of the (possibly non-leaf) index cell
+// that contains p, by walking up p's leaf cell's ancestors until one of
+// them is present in cellMap. It returns ok == false if the index has no
+// cell covering p at all.
+func (s *ShapeIndex) cellContaining(p Point) (id CellID, ok bool) {
+	leaf := CellIDFromPoint(p)
+	for lvl := leaf.Level(); lvl >= 0; lvl-- {
+		anc := leaf.Parent(lvl)
+		if _, found := s.cellMap[anc]; found {
+			return anc, true
+		}
+	}
+	return CellID(0), false
+}
+
+// Contains returns the IDs of every indexed shape whose interior contains
+// p. It looks up the (single) index cell containing p, then combines that
+// cell's clippedShape.containsCenter bit with the crossing parity of
+// whichever of the cell's edges lie between the cell's center and p --
+// shapes with no interior (points, polylines) never match.
+func (s *ShapeIndex) Contains(p Point) []int {
+	s.maybeApplyUpdates()
+
+	cellID, ok := s.cellContaining(p)
+	if !ok {
+		return nil
+	}
+	cell := s.cellMap[cellID]
+	center := CellFromCellID(cellID).Center()
+
+	var ids []int
+	for _, clipped := range cell.shapes {
+		shape := s.shapes[int(clipped.shapeID)]
+		if shape == nil || !shape.HasInterior() {
+			continue
+		}
+		inside := clipped.containsCenter
+		for _, e := range clipped.edges {
+			a, b := shape.Edge(e)
+			if edgesCross(center, p, a, b) {
+				inside = !inside
+			}
+		}
+		if inside {
+			ids = append(ids, int(clipped.shapeID))
+		}
+	}
+	return ids
+}
+
+// cellBoundDistance returns a lower bound on the distance from p to
+// anything inside id's cell, used to prioritize and prune the best-first
+// search in ClosestEdge.
+func cellBoundDistance(id CellID, p Point) s1.ChordAngle {
+	capBound := CellFromCellID(id).CapBound()
+	d := ChordAngleBetweenPoints(p, capBound.Center()).Angle()
+	r := capBound.Radius()
+	if d <= r {
+		return 0
+	}
+	return s1.ChordAngleFromAngle(d - r)
+}
+
+// cellQueueEntry is one entry in ClosestEdge's best-first search queue.
+type cellQueueEntry struct {
+	id   CellID
+	dist s1.ChordAngle
+}
+
+// cellQueue is a container/heap.Interface min-heap of cellQueueEntry,
+// ordered by dist so the nearest unexplored cell always pops first.
+type cellQueue []cellQueueEntry
+
+func (q cellQueue) Len() int            { return len(q) }
+func (q cellQueue) Less(i, j int) bool  { return q[i].dist < q[j].dist }
+func (q cellQueue) Swap(i, j int)       { q[i], q[j] = q[j], q[i] }
+func (q *cellQueue) Push(x interface{}) { *q = append(*q, x.(cellQueueEntry)) }
+func (q *cellQueue) Pop() interface{} {
+	old := *q
+	n := len(old)
+	e := old[n-1]
+	*q = old[:n-1]
+	return e
+}
+
+// ClosestEdge does a best-first search over the index's cells, ordered by
+// cellBoundDistance(cell, p), stopping at the first cell whose bound is no
+// closer than the closest edge found so far. It returns the
+// shape ID and edge ID of the closest edge, and its distance to p.
+func (s *ShapeIndex) ClosestEdge(p Point) (shapeID int, edgeID int, dist s1.ChordAngle) {
+	s.maybeApplyUpdates()
+
+	shapeID, edgeID = -1, -1
+	dist = s1.InfChordAngle()
+
+	queue := &cellQueue{}
+	heap.Init(queue)
+	for id := range s.cellMap {
+		heap.Push(queue, cellQueueEntry{id: id, dist: cellBoundDistance(id, p)})
+	}
+
+	for queue.Len() > 0 {
+		entry := heap.Pop(queue).(cellQueueEntry)
+		if entry.dist >= dist {
+			break
+		}
+		for _, clipped := range s.cellMap[entry.id].shapes {
+			shape := s.shapes[int(clipped.shapeID)]
+			for _, e := range clipped.edges {
+				a, b := shape.Edge(e)
+				if d := DistanceFromSegment(p, a, b); d < dist {
+					dist = d
+					shapeID = int(clipped.shapeID)
+					edgeID = e
+				}
+			}
+		}
+	}
+
+	return shapeID, edgeID, dist
+}
@@ -0,0 +1,129 @@
+/*
+Copyright 2016 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package s2
+
+import (
+	"math"
+	"testing"
+)
+
+// polygonShape is a minimal, test-only Shape: a closed loop of vertices
+// forming a simple polygon. It exists so these tests don't need the full
+// Loop/Polygon types, just enough of Shape to exercise the index.
+type polygonShape struct {
+	vertices []Point
+}
+
+// newRegularPolygon builds a roughly-circular polygon of numVertices edges
+// around center. Using more edges than maxEdgesPerCell (10) forces
+// maybeApplyUpdates to actually subdivide past the root face cell, which is
+// the only place the edge-to-child bucketing bug showed up.
+func newRegularPolygon(center LatLng, radiusDegrees float64, numVertices int) *polygonShape {
+	vertices := make([]Point, numVertices)
+	for i := 0; i < numVertices; i++ {
+		angle := 2 * math.Pi * float64(i) / float64(numVertices)
+		ll := LatLngFromDegrees(
+			center.Lat.Degrees()+radiusDegrees*math.Cos(angle),
+			center.Lng.Degrees()+radiusDegrees*math.Sin(angle),
+		)
+		vertices[i] = PointFromLatLng(ll)
+	}
+	return &polygonShape{vertices: vertices}
+}
+
+func (p *polygonShape) NumEdges() int { return len(p.vertices) }
+func (p *polygonShape) Edge(i int) (a, b Point) {
+	return p.vertices[i], p.vertices[(i+1)%len(p.vertices)]
+}
+func (p *polygonShape) numChains() int { return 1 }
+func (p *polygonShape) chainStart(i int) int {
+	if i == 0 {
+		return 0
+	}
+	return len(p.vertices)
+}
+func (p *polygonShape) dimension() dimension { return polygonGeometry }
+func (p *polygonShape) HasInterior() bool    { return true }
+func (p *polygonShape) ContainsOrigin() bool { return false }
+
+// fullSphereShape is a test-only Shape with no edges at all whose interior
+// covers the whole sphere, like a fully-degenerate "full" Loop.
+type fullSphereShape struct{}
+
+func (fullSphereShape) NumEdges() int           { return 0 }
+func (fullSphereShape) Edge(i int) (a, b Point) { return Point{}, Point{} }
+func (fullSphereShape) numChains() int          { return 0 }
+func (fullSphereShape) chainStart(i int) int    { return 0 }
+func (fullSphereShape) dimension() dimension    { return polygonGeometry }
+func (fullSphereShape) HasInterior() bool       { return true }
+func (fullSphereShape) ContainsOrigin() bool    { return true }
+
+func TestShapeIndexContainsWithSubdivision(t *testing.T) {
+	center := LatLngFromDegrees(10, 20)
+	poly := newRegularPolygon(center, 1.0, 24)
+
+	index := NewShapeIndex()
+	index.Add(poly)
+
+	if ids := index.Contains(PointFromLatLng(center)); len(ids) != 1 {
+		t.Fatalf("Contains(center) = %v, want exactly one shape", ids)
+	}
+
+	far := PointFromLatLng(LatLngFromDegrees(10, 10))
+	if ids := index.Contains(far); len(ids) != 0 {
+		t.Fatalf("Contains(far outside point) = %v, want none", ids)
+	}
+}
+
+func TestShapeIndexClosestEdgeWithSubdivision(t *testing.T) {
+	center := LatLngFromDegrees(0, 0)
+	poly := newRegularPolygon(center, 1.0, 24)
+
+	index := NewShapeIndex()
+	index.Add(poly)
+
+	// Just outside the vertex that sits due north of center.
+	query := PointFromLatLng(LatLngFromDegrees(1.05, 0))
+	shapeID, edgeID, dist := index.ClosestEdge(query)
+	if shapeID != 0 {
+		t.Fatalf("ClosestEdge shapeID = %d, want 0", shapeID)
+	}
+	if edgeID < 0 {
+		t.Fatalf("ClosestEdge edgeID = %d, want a valid edge index", edgeID)
+	}
+	if got := dist.Angle().Degrees(); got > 1.0 {
+		t.Fatalf("ClosestEdge distance = %v degrees, want well under 1", got)
+	}
+}
+
+// TestShapeIndexContainsFullShapeOnEveryFace checks that a shape with no
+// edges and a whole-sphere interior is found by Contains for query points on
+// every cube face, not just face 0 (maybeApplyUpdates must register it under
+// every face's root cell, since cellContaining only walks up the query
+// point's own leaf cell ancestors).
+func TestShapeIndexContainsFullShapeOnEveryFace(t *testing.T) {
+	index := NewShapeIndex()
+	index.Add(fullSphereShape{})
+
+	for f := 0; f < 6; f++ {
+		p := CellIDFromFace(f).Point()
+		ids := index.Contains(p)
+		if len(ids) != 1 {
+			t.Fatalf("Contains(point on face %d) = %v, want exactly one shape", f, ids)
+		}
+	}
+}